@@ -0,0 +1,385 @@
+// Copyright 2016-2018, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/pulumi/pulumi/pkg/util/contract"
+)
+
+const (
+	// defaultRegistryIndexURL is used to resolve `registry://name` template sources when the
+	// user hasn't configured an alternate index via PULUMI_TEMPLATE_REGISTRY.
+	defaultRegistryIndexURL = "https://registry.pulumi.com/templates/index.json"
+
+	// templateRegistryEnvVar overrides the default registry index URL.
+	templateRegistryEnvVar = "PULUMI_TEMPLATE_REGISTRY"
+)
+
+// RegistryEntry describes a single template published to the template registry index.
+type RegistryEntry struct {
+	// Name is the name under which the template is published, e.g. "aws-typescript".
+	Name string `json:"name"`
+	// Description is a short, human readable summary of the template.
+	Description string `json:"description"`
+	// Source is the underlying `git+https://` or `https://` URL this entry resolves to.
+	Source string `json:"source"`
+	// Checksum, if present, is the expected hex-encoded sha256 digest of the tarball at Source,
+	// verified before it's extracted. Only meaningful when Source is an HTTP(S) tarball URL.
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// ListRegistryTemplates returns every template published to the configured registry index.
+func ListRegistryTemplates() ([]RegistryEntry, error) {
+	return fetchRegistryIndex(registryIndexURL())
+}
+
+// SearchRegistryTemplates returns the registry templates whose name or description contains query.
+func SearchRegistryTemplates(query string) ([]RegistryEntry, error) {
+	entries, err := ListRegistryTemplates()
+	if err != nil {
+		return nil, err
+	}
+
+	query = strings.ToLower(query)
+	var results []RegistryEntry
+	for _, e := range entries {
+		if strings.Contains(strings.ToLower(e.Name), query) || strings.Contains(strings.ToLower(e.Description), query) {
+			results = append(results, e)
+		}
+	}
+	return results, nil
+}
+
+// FetchTemplate fetches a template from a remote source and installs it into the local template
+// cache, returning the resulting Template. source may be:
+//
+//   - a Git URL, prefixed with "git+" (e.g. "git+https://github.com/org/repo") unless it ends in
+//     ".git" or carries a "//subdir" selector (an unprefixed URL with neither is treated as an
+//     HTTP(S) tarball), optionally suffixed with "//subdir" to select a subdirectory of the repo
+//     as the template root, and optionally suffixed with "#ref" to select a branch, tag, or commit;
+//   - an HTTP(S) URL to a gzipped tarball (e.g. "https://example.com/foo.tar.gz");
+//   - a "registry://name" URL, resolved against the template registry index.
+//
+// A template resolved via "registry://" has its tarball checksummed against the registry index's
+// manifest entry for it (RegistryEntry.Checksum), when the entry declares one; a source fetched
+// directly as a Git or HTTP(S) URL has no such manifest to check against.
+func FetchTemplate(source string) (Template, error) {
+	contract.Require(source != "", "source")
+
+	switch {
+	case strings.HasPrefix(source, "registry://"):
+		return fetchRegistryTemplate(strings.TrimPrefix(source, "registry://"))
+
+	case strings.HasPrefix(source, "git+") || isGitURL(source):
+		return fetchGitTemplate(strings.TrimPrefix(source, "git+"))
+
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		return fetchHTTPTemplate(source, "" /*wantChecksum*/)
+
+	default:
+		return Template{}, errors.Errorf("unrecognized template source %q", source)
+	}
+}
+
+// isGitURL returns true if source looks like a Git remote rather than a plain HTTP(S) tarball
+// link, e.g. it ends in ".git" or contains a "//subdir" selector. It defers to parseGitSource for
+// stripping the "#ref" fragment and scheme before checking, since a naive split on "//" would
+// otherwise match the "//" in the URL's own "https://" scheme separator.
+func isGitURL(source string) bool {
+	repo, _, subdir := parseGitSource(source)
+	return strings.HasSuffix(repo, ".git") || subdir != ""
+}
+
+func fetchRegistryTemplate(name string) (Template, error) {
+	entries, err := ListRegistryTemplates()
+	if err != nil {
+		return Template{}, errors.Wrap(err, "listing template registry")
+	}
+
+	for _, e := range entries {
+		if e.Name == name {
+			return fetchRegistryEntry(e)
+		}
+	}
+	return Template{}, errors.Errorf("no template named %q in the template registry", name)
+}
+
+// fetchRegistryEntry fetches the template published as entry, verifying entry's Checksum (when
+// declared) against the downloaded tarball if entry.Source is an HTTP(S) URL.
+func fetchRegistryEntry(entry RegistryEntry) (Template, error) {
+	source := entry.Source
+	switch {
+	case strings.HasPrefix(source, "git+") || isGitURL(source):
+		return fetchGitTemplate(strings.TrimPrefix(source, "git+"))
+
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		return fetchHTTPTemplate(source, entry.Checksum)
+
+	default:
+		return Template{}, errors.Errorf("unrecognized template source %q for %q", source, entry.Name)
+	}
+}
+
+func fetchRegistryIndex(indexURL string) ([]RegistryEntry, error) {
+	resp, err := http.Get(indexURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetching template registry index %s", indexURL)
+	}
+	defer contract.IgnoreClose(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("fetching template registry index %s: %s", indexURL, resp.Status)
+	}
+
+	var entries []RegistryEntry
+	if err = json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, errors.Wrapf(err, "parsing template registry index %s", indexURL)
+	}
+	return entries, nil
+}
+
+func registryIndexURL() string {
+	if u := os.Getenv(templateRegistryEnvVar); u != "" {
+		return u
+	}
+	return defaultRegistryIndexURL
+}
+
+// fetchGitTemplate clones a Git repository (optionally a specific ref, optionally a subdirectory)
+// into the template cache under name.
+func fetchGitTemplate(source string) (Template, error) {
+	repo, ref, subdir := parseGitSource(source)
+
+	if err := validateGitArg("repository", repo); err != nil {
+		return Template{}, err
+	}
+	if ref != "" {
+		if err := validateGitArg("ref", ref); err != nil {
+			return Template{}, err
+		}
+	}
+
+	name := templateNameFromSource(repo, subdir)
+	templateDir, err := GetTemplateDir(name)
+	if err != nil {
+		return Template{}, err
+	}
+
+	tmpDir, err := ioutil.TempDir("", "pulumi-template-clone")
+	if err != nil {
+		return Template{}, errors.Wrap(err, "creating temporary clone directory")
+	}
+	defer contract.IgnoreError(os.RemoveAll(tmpDir))
+
+	// "git clone --branch" only accepts a branch or tag name, not an arbitrary commit SHA, so a
+	// ref can only be requested via a shallow clone when it's the default branch. When a ref is
+	// given, fall back to a full clone followed by an explicit checkout, which works uniformly
+	// for a branch, tag, or commit.
+	cloneArgs := []string{"clone"}
+	if ref == "" {
+		cloneArgs = append(cloneArgs, "--depth", "1")
+	}
+	// "--" ends option parsing, so repo and tmpDir are always taken as the positional <repository>
+	// and <directory> arguments, never as flags: without it, a repo string like
+	// "--upload-pack=evil" would be parsed by git as an option rather than a URL, letting an
+	// untrusted template source run an arbitrary command as the clone's "remote" side.
+	cloneArgs = append(cloneArgs, "--", repo, tmpDir)
+
+	cmd := exec.Command("git", cloneArgs...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return Template{}, errors.Wrapf(err, "cloning %s: %s", repo, string(out))
+	}
+
+	if ref != "" {
+		// validateGitArg has already rejected a ref beginning with "-", so this can't be parsed as
+		// an option; the trailing "--" additionally marks it unambiguously as a revision rather
+		// than a pathspec.
+		checkout := exec.Command("git", "-C", tmpDir, "checkout", ref, "--")
+		if out, err := checkout.CombinedOutput(); err != nil {
+			return Template{}, errors.Wrapf(err, "checking out %s in %s: %s", ref, repo, string(out))
+		}
+	}
+
+	sourceDir := tmpDir
+	if subdir != "" {
+		sourceDir = filepath.Join(tmpDir, subdir)
+		if info, statErr := os.Stat(sourceDir); statErr != nil || !info.IsDir() {
+			return Template{}, errors.Errorf("subdirectory %q not found in %s", subdir, repo)
+		}
+	}
+
+	if err = os.RemoveAll(templateDir); err != nil {
+		return Template{}, errors.Wrapf(err, "removing existing template directory %s", templateDir)
+	}
+	if err = copyDir(sourceDir, templateDir); err != nil {
+		return Template{}, errors.Wrapf(err, "copying cloned template into %s", templateDir)
+	}
+
+	return LoadLocalTemplate(name)
+}
+
+// parseGitSource splits a Git template source into its repo URL, optional ref, and optional
+// subdirectory, e.g. "https://github.com/org/repo//subdir#mybranch" becomes
+// ("https://github.com/org/repo", "mybranch", "subdir").
+func parseGitSource(source string) (repo string, ref string, subdir string) {
+	repo = source
+	if idx := strings.Index(repo, "#"); idx != -1 {
+		ref = repo[idx+1:]
+		repo = repo[:idx]
+	}
+	if schemeIdx := strings.Index(repo, "://"); schemeIdx != -1 {
+		if rest := strings.Index(repo[schemeIdx+3:], "//"); rest != -1 {
+			idx := schemeIdx + 3 + rest
+			subdir = repo[idx+2:]
+			repo = repo[:idx]
+		}
+	}
+	return repo, ref, subdir
+}
+
+// validateGitArg rejects a value that git would interpret as an option rather than as the
+// positional repository or ref argument callers intend it to be. exec.Command doesn't invoke a
+// shell, so this isn't a shell-injection concern, but an untrusted repo or ref string beginning
+// with "-" can still smuggle an arbitrary flag into the git invocation — most dangerously
+// "--upload-pack=<cmd>", which makes git run an arbitrary command as the "remote" side of a clone.
+func validateGitArg(kind string, value string) error {
+	if strings.HasPrefix(value, "-") {
+		return errors.Errorf("invalid git %s %q: must not begin with \"-\"", kind, value)
+	}
+	return nil
+}
+
+// fetchHTTPTemplate downloads a gzipped tarball over HTTP(S), optionally verifies it against
+// wantChecksum (a hex-encoded sha256 digest, typically sourced from a registry manifest entry —
+// see RegistryEntry.Checksum), and installs it into the template cache.
+func fetchHTTPTemplate(url string, wantChecksum string) (Template, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return Template{}, errors.Wrapf(err, "downloading template from %s", url)
+	}
+	defer contract.IgnoreClose(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return Template{}, errors.Errorf("downloading template from %s: %s", url, resp.Status)
+	}
+
+	// Bound the same way extractTarball bounds the decompressed archive: without a limit here, an
+	// oversized or unbounded response body is read into memory in full before that check ever
+	// gets a chance to run.
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxTemplateTarballSize+1))
+	if err != nil {
+		return Template{}, errors.Wrapf(err, "reading template download from %s", url)
+	}
+	if int64(len(body)) > MaxTemplateTarballSize {
+		return Template{}, errors.Errorf(
+			"refusing to download template from %s: exceeds the %d byte limit", url, MaxTemplateTarballSize)
+	}
+
+	if wantChecksum != "" {
+		if err = verifyChecksum(url, body, wantChecksum); err != nil {
+			return Template{}, err
+		}
+	}
+
+	name := templateNameFromSource(url, "")
+	if err = InstallTemplate(name, ioutil.NopCloser(bytes.NewReader(body))); err != nil {
+		return Template{}, err
+	}
+	return LoadLocalTemplate(name)
+}
+
+// verifyChecksum returns an error if the sha256 of data doesn't match want (a hex-encoded digest,
+// compared case-insensitively).
+func verifyChecksum(url string, data []byte, want string) error {
+	sum := sha256.Sum256(data)
+	got := hex.EncodeToString(sum[:])
+	if !strings.EqualFold(got, want) {
+		return errors.Errorf("checksum mismatch for %s: expected sha256:%s, got sha256:%s", url, want, got)
+	}
+	return nil
+}
+
+// templateNameFromSource derives a stable template cache name from a remote source location.
+func templateNameFromSource(source string, subdir string) string {
+	name := strings.TrimSuffix(source, ".git")
+	name = strings.TrimSuffix(name, ".tar.gz")
+	if idx := strings.LastIndex(name, "/"); idx != -1 {
+		name = name[idx+1:]
+	}
+	if subdir != "" {
+		name = name + "-" + strings.Replace(subdir, "/", "-", -1)
+	}
+	if name == "" {
+		sum := sha256.Sum256([]byte(source))
+		name = hex.EncodeToString(sum[:])[:12]
+	}
+	return name
+}
+
+// copyDir recursively copies sourceDir into destDir, creating destDir and any intermediate
+// directories as needed, skipping any ".git" directory it encounters. Used to move a cloned Git
+// checkout into the template cache without bringing its VCS metadata along. This doesn't reuse
+// walkFiles, since walkFiles always recurses into a directory after visiting it and so can't skip
+// a subtree the way excluding ".git" requires.
+func copyDir(sourceDir string, destDir string) error {
+	if err := os.MkdirAll(destDir, 0700); err != nil {
+		return err
+	}
+
+	infos, err := ioutil.ReadDir(sourceDir)
+	if err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		name := info.Name()
+		if name == ".git" {
+			continue
+		}
+
+		source := filepath.Join(sourceDir, name)
+		dest := filepath.Join(destDir, name)
+
+		if info.IsDir() {
+			if err := copyDir(source, dest); err != nil {
+				return err
+			}
+			continue
+		}
+
+		b, err := ioutil.ReadFile(source)
+		if err != nil {
+			return err
+		}
+		if err := writeAllBytes(dest, b, true /*overwrite*/); err != nil {
+			return err
+		}
+	}
+	return nil
+}
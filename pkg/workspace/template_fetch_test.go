@@ -0,0 +1,119 @@
+// Copyright 2016-2018, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyChecksum(t *testing.T) {
+	data := []byte("template contents")
+	sum := sha256.Sum256(data)
+	hexSum := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{"matching checksum", data, hexSum, false},
+		{"matching checksum, different case", data, strings.ToUpper(hexSum), false},
+		{"mismatched checksum", data, strings.Repeat("0", len(hexSum)), true},
+		{"corrupted data", []byte("tampered contents"), hexSum, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyChecksum("https://example.com/template.tar.gz", tt.data, tt.want)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestParseGitSource(t *testing.T) {
+	tests := []struct {
+		name       string
+		source     string
+		wantRepo   string
+		wantRef    string
+		wantSubdir string
+	}{
+		{"plain repo", "https://github.com/org/repo", "https://github.com/org/repo", "", ""},
+		{"repo with ref", "https://github.com/org/repo#v1.0.0", "https://github.com/org/repo", "v1.0.0", ""},
+		{"repo with subdir", "https://github.com/org/repo//subdir", "https://github.com/org/repo", "", "subdir"},
+		{"repo with subdir and ref",
+			"https://github.com/org/repo//subdir#mybranch", "https://github.com/org/repo", "mybranch", "subdir"},
+		{"bare .git URL with subdir", "https://github.com/org/repo.git//subdir#v1",
+			"https://github.com/org/repo.git", "v1", "subdir"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, ref, subdir := parseGitSource(tt.source)
+			assert.Equal(t, tt.wantRepo, repo)
+			assert.Equal(t, tt.wantRef, ref)
+			assert.Equal(t, tt.wantSubdir, subdir)
+		})
+	}
+}
+
+func TestIsGitURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   bool
+	}{
+		{"dot-git suffix", "https://github.com/org/repo.git", true},
+		{"subdir selector", "https://github.com/org/repo//subdir", true},
+		{"dot-git suffix with subdir and ref", "https://github.com/org/repo.git//subdir#v1", true},
+		{"plain http tarball", "https://example.com/foo.tar.gz", false},
+		{"plain https repo with no markers", "https://github.com/org/repo", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isGitURL(tt.source))
+		})
+	}
+}
+
+func TestTemplateNameFromSource(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		subdir string
+		want   string
+	}{
+		{"git URL", "https://github.com/org/repo.git", "", "repo"},
+		{"tarball URL", "https://example.com/foo.tar.gz", "", "foo"},
+		{"with subdir", "https://github.com/org/repo", "nested/dir", "repo-nested-dir"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, templateNameFromSource(tt.source, tt.subdir))
+		})
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2016-2018, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TemplateVariable describes a single value a template prompts for and makes available to its
+// files via text/template, e.g. a cloud region or a boolean feature flag.
+type TemplateVariable struct {
+	// The name of the variable, referenced in templates as {{.Name}} and in -c overrides.
+	Name string `json:"name" yaml:"name"`
+	// The text shown to the user when prompting for this variable interactively.
+	Prompt string `json:"prompt" yaml:"prompt"`
+	// The value used when the user accepts the default (--yes) or presses enter at the prompt.
+	Default string `json:"default" yaml:"default"`
+	// Optional regular expression the value must match.
+	Regex string `json:"regex" yaml:"regex"`
+	// Optional set of allowed values; if non-empty, the value must be one of these.
+	Choices []string `json:"choices" yaml:"choices"`
+}
+
+// TemplateValues holds the resolved values for a template's built-in and user-declared
+// variables, keyed by name, ready to be passed to CopyTemplateFiles.
+type TemplateValues map[string]string
+
+// WithBuiltins returns a copy of values with the Project, Description, Author, and Year built-ins
+// populated from projectName/projectDescription (and the current user and year) wherever the
+// template hasn't already declared a variable of the same name.
+func (values TemplateValues) WithBuiltins(projectName string, projectDescription string) TemplateValues {
+	result := make(TemplateValues, len(values)+4)
+	for k, v := range values {
+		result[k] = v
+	}
+
+	if _, ok := result["Project"]; !ok {
+		result["Project"] = projectName
+	}
+	if _, ok := result["Description"]; !ok {
+		result["Description"] = projectDescription
+	}
+	if _, ok := result["Author"]; !ok {
+		result["Author"] = currentUserName()
+	}
+	if _, ok := result["Year"]; !ok {
+		result["Year"] = strconv.Itoa(time.Now().Year())
+	}
+	return result
+}
+
+// CollectTemplateVariables resolves a value for each of template's declared Variables. For each
+// variable, a cliOverrides entry (as supplied via `pulumi new`'s repeatable -c flag) takes
+// precedence; otherwise, if yes is true, the variable's Default is used; otherwise the user is
+// prompted on w/r, re-prompting until the entered value validates.
+func CollectTemplateVariables(
+	template Template, yes bool, cliOverrides map[string]string, r io.Reader, w io.Writer) (TemplateValues, error) {
+
+	values := make(TemplateValues)
+	reader := bufio.NewReader(r)
+
+	for _, v := range template.Variables {
+		if override, has := cliOverrides[v.Name]; has {
+			if err := v.validate(override); err != nil {
+				return nil, err
+			}
+			values[v.Name] = override
+			continue
+		}
+
+		if yes {
+			if err := v.validate(v.Default); err != nil {
+				return nil, errors.Wrapf(err, "template declares an invalid default for %s", v.Name)
+			}
+			values[v.Name] = v.Default
+			continue
+		}
+
+		value, err := promptTemplateVariable(v, reader, w)
+		if err != nil {
+			return nil, err
+		}
+		values[v.Name] = value
+	}
+
+	return values, nil
+}
+
+// promptTemplateVariable prompts for and validates a single variable, reprompting on invalid input.
+func promptTemplateVariable(v TemplateVariable, reader *bufio.Reader, w io.Writer) (string, error) {
+	prompt := v.Prompt
+	if prompt == "" {
+		prompt = v.Name
+	}
+
+	for {
+		if len(v.Choices) > 0 {
+			fmt.Fprintf(w, "%s (%s) [%s]: ", prompt, strings.Join(v.Choices, "/"), v.Default)
+		} else {
+			fmt.Fprintf(w, "%s [%s]: ", prompt, v.Default)
+		}
+
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", errors.Wrapf(err, "reading value for %s", v.Name)
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			line = v.Default
+		}
+
+		if err := v.validate(line); err != nil {
+			fmt.Fprintln(w, err)
+			continue
+		}
+		return line, nil
+	}
+}
+
+// validate returns an error if value isn't one of v.Choices (when declared) or doesn't match
+// v.Regex (when declared).
+func (v TemplateVariable) validate(value string) error {
+	if len(v.Choices) > 0 {
+		for _, choice := range v.Choices {
+			if value == choice {
+				return nil
+			}
+		}
+		return errors.Errorf("%q is not a valid value for %s; expected one of: %s", value, v.Name, strings.Join(v.Choices, ", "))
+	}
+
+	if v.Regex != "" {
+		re, err := regexp.Compile(v.Regex)
+		if err != nil {
+			return errors.Wrapf(err, "invalid regex for variable %s", v.Name)
+		}
+		if !re.MatchString(value) {
+			return errors.Errorf("%q is not a valid value for %s", value, v.Name)
+		}
+	}
+
+	return nil
+}
+
+// currentUserName returns the best-effort name of the current user, for the Author built-in.
+func currentUserName() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
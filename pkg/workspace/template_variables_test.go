@@ -0,0 +1,99 @@
+// Copyright 2016-2018, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateVariableValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		v       TemplateVariable
+		value   string
+		wantErr bool
+	}{
+		{"no constraints accepts anything", TemplateVariable{Name: "x"}, "whatever", false},
+		{"choice matches", TemplateVariable{Name: "cloud", Choices: []string{"aws", "azure"}}, "aws", false},
+		{"choice mismatch", TemplateVariable{Name: "cloud", Choices: []string{"aws", "azure"}}, "gcp", true},
+		{"regex matches", TemplateVariable{Name: "region", Regex: `^[a-z]+-[a-z]+-\d$`}, "us-west-2", false},
+		{"regex mismatch", TemplateVariable{Name: "region", Regex: `^[a-z]+-[a-z]+-\d$`}, "not-a-region", true},
+		{"choices take precedence over regex", TemplateVariable{
+			Name: "x", Choices: []string{"a"}, Regex: `.*`}, "b", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.v.validate(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCollectTemplateVariablesYesUsesValidatedDefault(t *testing.T) {
+	template := Template{
+		Variables: []TemplateVariable{
+			{Name: "cloud", Default: "aws", Choices: []string{"aws", "azure"}},
+		},
+	}
+
+	values, err := CollectTemplateVariables(template, true /*yes*/, nil, strings.NewReader(""), &strings.Builder{})
+	assert.NoError(t, err)
+	assert.Equal(t, "aws", values["cloud"])
+}
+
+func TestCollectTemplateVariablesYesRejectsInvalidDefault(t *testing.T) {
+	template := Template{
+		Variables: []TemplateVariable{
+			{Name: "cloud", Default: "gcp", Choices: []string{"aws", "azure"}},
+		},
+	}
+
+	_, err := CollectTemplateVariables(template, true /*yes*/, nil, strings.NewReader(""), &strings.Builder{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "cloud")
+}
+
+func TestCollectTemplateVariablesCLIOverrideTakesPrecedence(t *testing.T) {
+	template := Template{
+		Variables: []TemplateVariable{
+			{Name: "cloud", Default: "aws", Choices: []string{"aws", "azure"}},
+		},
+	}
+
+	values, err := CollectTemplateVariables(
+		template, true /*yes*/, map[string]string{"cloud": "azure"}, strings.NewReader(""), &strings.Builder{})
+	assert.NoError(t, err)
+	assert.Equal(t, "azure", values["cloud"])
+}
+
+func TestCollectTemplateVariablesCLIOverrideMustValidate(t *testing.T) {
+	template := Template{
+		Variables: []TemplateVariable{
+			{Name: "cloud", Default: "aws", Choices: []string{"aws", "azure"}},
+		},
+	}
+
+	_, err := CollectTemplateVariables(
+		template, true /*yes*/, map[string]string{"cloud": "gcp"}, strings.NewReader(""), &strings.Builder{})
+	assert.Error(t, err)
+}
@@ -16,15 +16,18 @@ package workspace
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/user"
+	"path"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"text/template"
 
 	"gopkg.in/yaml.v2"
 
@@ -52,8 +55,45 @@ type Template struct {
 	InstallDependencies bool `json:"installdependencies" yaml:"installdependencies"`
 	// Optional default config values.
 	Config map[config.Key]string `json:"config" yaml:"config"`
+	// Optional language this template targets (e.g. "typescript", "python"). Used together with
+	// Runtime to select a more specific template over the shared "default" one.
+	Language string `json:"language" yaml:"language"`
+	// Optional runtime/cloud flavor this template targets (e.g. "aws", "azure").
+	Runtime string `json:"runtime" yaml:"runtime"`
+	// Optional name of a template whose files this template inherits. Files in this template
+	// override files of the same name inherited from Parent. If Parent is empty and this
+	// template isn't itself named "default", a sibling "default" template (if one exists in the
+	// template cache) is used as an implicit parent.
+	Parent string `json:"parent" yaml:"parent"`
+	// Optional set of variables this template prompts for and makes available to its files via
+	// text/template, in addition to the built-in Project/Description/Author/Year.
+	Variables []TemplateVariable `json:"variables" yaml:"variables"`
+	// Optional .gitattributes-style rules controlling line-ending handling during extraction.
+	EOL []TemplateEOLRule `json:"eol" yaml:"eol"`
+	// Optional set of path patterns identifying which files are exempted from being rendered as a
+	// text/template during CopyTemplateFiles. A pattern with no "/" matches a file of that name at
+	// any depth; one containing "/" matches the full path from the template root (the same
+	// matching rules as EOL.Pattern). A file matching one of these patterns is copied byte for
+	// byte instead, so a template can ship files that contain their own literal "{{ ... }}" syntax
+	// — a GitHub Actions workflow using "${{ secrets.X }}", a Helm chart, a Go-template fixture —
+	// without those files being corrupted by variable substitution. Templates that don't declare
+	// NoRender keep the historical behavior of rendering every non-binary file.
+	NoRender []string `json:"norender" yaml:"norender"`
 }
 
+// TemplateEOLRule is a single .gitattributes-style line-ending rule. Pattern is matched via
+// path.Match against a file's base name if it contains no "/", or against the file's full path
+// within the template otherwise, and Attribute is one of "text", "binary", "eol=lf", or
+// "eol=crlf". The last matching rule wins, as with .gitattributes.
+type TemplateEOLRule struct {
+	Pattern   string `json:"pattern" yaml:"pattern"`
+	Attribute string `json:"attribute" yaml:"attribute"`
+}
+
+// defaultTemplateName is the name of the template used as an implicit parent for templates that
+// don't declare one explicitly, allowing a family of templates to share common files.
+const defaultTemplateName = "default"
+
 // LoadLocalTemplate returns a local template.
 func LoadLocalTemplate(name string) (Template, error) {
 	templateDir, err := GetTemplateDir(name)
@@ -79,7 +119,10 @@ func LoadLocalTemplate(name string) (Template, error) {
 	return template, nil
 }
 
-// ListLocalTemplates returns a list of local templates.
+// ListLocalTemplates returns the local templates a user can select directly. The "default"
+// template, if present, is a shared base that other templates inherit from (see Template.Parent
+// and SelectLocalTemplate) rather than something meant to be instantiated on its own, so it's
+// excluded from this list.
 func ListLocalTemplates() ([]Template, error) {
 	templateDir, err := GetTemplateDir("")
 	if err != nil {
@@ -93,7 +136,7 @@ func ListLocalTemplates() ([]Template, error) {
 
 	var templates []Template
 	for _, info := range infos {
-		if info.IsDir() {
+		if info.IsDir() && info.Name() != defaultTemplateName {
 			template, err := LoadLocalTemplate(info.Name())
 			if err != nil {
 				return nil, err
@@ -104,6 +147,39 @@ func ListLocalTemplates() ([]Template, error) {
 	return templates, nil
 }
 
+// SelectLocalTemplate resolves the best local template for the given language and runtime/cloud
+// flavor, preferring the most specific match: an exact (language, runtime) match, then a match on
+// language alone, then a match on runtime alone, then the shared "default" template. language and
+// runtime may be empty to skip matching on that dimension.
+func SelectLocalTemplate(language string, runtime string) (Template, error) {
+	templates, err := ListLocalTemplates()
+	if err != nil {
+		return Template{}, err
+	}
+
+	var languageMatch, runtimeMatch *Template
+	for i := range templates {
+		t := &templates[i]
+		switch {
+		case language != "" && runtime != "" && t.Language == language && t.Runtime == runtime:
+			return *t, nil
+		case language != "" && t.Language == language && languageMatch == nil:
+			languageMatch = t
+		case runtime != "" && t.Runtime == runtime && runtimeMatch == nil:
+			runtimeMatch = t
+		}
+	}
+
+	if languageMatch != nil {
+		return *languageMatch, nil
+	}
+	if runtimeMatch != nil {
+		return *runtimeMatch, nil
+	}
+
+	return LoadLocalTemplate(defaultTemplateName)
+}
+
 // InstallTemplate installs a template tarball into the local cache.
 func InstallTemplate(name string, tarball io.ReadCloser) error {
 	contract.Require(name != "", "name")
@@ -127,39 +203,49 @@ func InstallTemplate(name string, tarball io.ReadCloser) error {
 		return errors.Wrapf(err, "creating template directory %s", templateDir)
 	}
 
-	// Extract the tarball to its directory.
+	// Extract the tarball to its directory. Windows line-ending conversion, where needed,
+	// happens inline as each file is extracted rather than as a second pass over the tree.
 	if err = extractTarball(tarball, templateDir); err != nil {
 		return errors.Wrapf(err, "extracting template to %s", templateDir)
 	}
 
-	// On Windows, we need to replace \n with \r\n. We'll just do this as a separate step.
-	if runtime.GOOS == "windows" {
-		if err = fixWindowsLineEndings(templateDir); err != nil {
-			return errors.Wrapf(err, "fixing line endings in %s", templateDir)
-		}
-	}
-
 	return nil
 }
 
 // CopyTemplateFilesDryRun does a dry run of copying a template to a destination directory,
-// to ensure it won't overwrite any files.
-func (template Template) CopyTemplateFilesDryRun(destDir string) error {
-	var err error
-	var sourceDir string
-	if sourceDir, err = GetTemplateDir(template.Name); err != nil {
+// to ensure it won't overwrite any files. variables is used to resolve conditional files (see
+// CopyTemplateFiles) so the dry run only flags files that would actually be copied.
+func (template Template) CopyTemplateFilesDryRun(destDir string, variables TemplateValues) error {
+	sourceDirs, err := template.sourceDirs()
+	if err != nil {
 		return err
 	}
+	return copyTemplateFilesDryRun(sourceDirs, destDir, variables)
+}
 
+// copyTemplateFilesDryRun is the sourceDirs-driven core of CopyTemplateFilesDryRun, split out so
+// the overlay logic can be exercised with an explicit ancestry chain in tests.
+func copyTemplateFilesDryRun(sourceDirs []string, destDir string, variables TemplateValues) error {
 	var existing []string
-	err = walkFiles(sourceDir, destDir, func(info os.FileInfo, source string, dest string) error {
-		if destInfo, statErr := os.Stat(dest); statErr == nil && !destInfo.IsDir() {
-			existing = append(existing, filepath.Base(dest))
+	seen := make(map[string]bool)
+	for _, sourceDir := range sourceDirs {
+		err := walkFiles(sourceDir, destDir, func(info os.FileInfo, source string, dest string) error {
+			if !info.IsDir() {
+				var included bool
+				dest, included = resolveConditionalDest(dest, variables)
+				if !included {
+					return nil
+				}
+			}
+			if destInfo, statErr := os.Stat(dest); statErr == nil && !destInfo.IsDir() && !seen[dest] {
+				seen[dest] = true
+				existing = append(existing, filepath.Base(dest))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
-		return nil
-	})
-	if err != nil {
-		return err
 	}
 
 	if len(existing) > 0 {
@@ -168,44 +254,170 @@ func (template Template) CopyTemplateFilesDryRun(destDir string) error {
 	return nil
 }
 
-// CopyTemplateFiles does the actual copy operation to a destination directory.
+// CopyTemplateFiles does the actual copy operation to a destination directory. If the template
+// has a Parent (explicit or the implicit "default" fallback), that ancestry is overlaid first,
+// from the root of the chain down to the template itself, so a specialized template only needs
+// to ship the files that differ from its parent.
+//
+// A file is rendered as a text/template using variables (which should already include the
+// built-ins from TemplateValues.WithBuiltins) if it's non-binary, unless it matches one of the
+// ancestry's NoRender patterns, in which case it's copied byte for byte so a template can ship
+// files containing their own literal "{{ ... }}" syntax without those files being corrupted by
+// variable substitution. A file whose name begins with "_if_<variable>_" is only copied when
+// variables[<variable>] is truthy, with that prefix stripped from the destination name, letting
+// one template cover multiple runtimes.
 func (template Template) CopyTemplateFiles(
-	destDir string, force bool, projectName string, projectDescription string) error {
+	destDir string, force bool, variables TemplateValues) error {
 
-	sourceDir, err := GetTemplateDir(template.Name)
+	sourceDirs, err := template.sourceDirs()
 	if err != nil {
 		return err
 	}
+	noRenderPatterns, err := template.noRenderPatterns()
+	if err != nil {
+		return err
+	}
+	return copyTemplateFiles(sourceDirs, destDir, force, variables, noRenderPatterns)
+}
 
-	return walkFiles(sourceDir, destDir, func(info os.FileInfo, source string, dest string) error {
-		if info.IsDir() {
-			// Create the destination directory.
-			return os.Mkdir(dest, 0700)
-		}
+// noRenderPatterns returns the union of NoRender patterns declared by template and its ancestry
+// (see templateAncestry), so a shared "default" template can exempt its own files from variable
+// substitution even when a more specific child template doesn't redeclare the same patterns.
+func (template Template) noRenderPatterns() ([]string, error) {
+	chain, err := templateAncestry(template)
+	if err != nil {
+		return nil, err
+	}
 
-		// Read the source file.
-		b, err := ioutil.ReadFile(source)
+	var patterns []string
+	for _, t := range chain {
+		patterns = append(patterns, t.NoRender...)
+	}
+	return patterns, nil
+}
+
+// copyTemplateFiles is the sourceDirs-driven core of CopyTemplateFiles, split out so the overlay
+// logic can be exercised with an explicit ancestry chain in tests.
+func copyTemplateFiles(
+	sourceDirs []string, destDir string, force bool, variables TemplateValues, noRenderPatterns []string) error {
+
+	written := make(map[string]bool)
+	for _, sourceDir := range sourceDirs {
+		err := walkFiles(sourceDir, destDir, func(info os.FileInfo, source string, dest string) error {
+			if info.IsDir() {
+				// Create the destination directory if a previous layer hasn't already.
+				if written[dest] {
+					return nil
+				}
+				if err := os.MkdirAll(dest, 0700); err != nil {
+					return err
+				}
+				written[dest] = true
+				return nil
+			}
+
+			var included bool
+			dest, included = resolveConditionalDest(dest, variables)
+			if !included {
+				return nil
+			}
+
+			// Read the source file.
+			b, err := ioutil.ReadFile(source)
+			if err != nil {
+				return err
+			}
+
+			// Render unless it's binary or the template has explicitly exempted this file
+			// from variable substitution.
+			result := b
+			relPath := filepath.ToSlash(relOrBase(destDir, dest))
+			if !isBinary(b) && !matchesAnyPattern(relPath, noRenderPatterns) {
+				rendered, err := renderTemplateFile(source, string(b), variables)
+				if err != nil {
+					return err
+				}
+				result = []byte(rendered)
+			}
+
+			// A more specific layer is always allowed to overwrite what an earlier, less
+			// specific layer in the same operation just wrote; only the first write to a given
+			// path needs to respect force.
+			overwrite := force || written[dest]
+
+			// Write to the destination file.
+			err = writeAllBytes(dest, result, overwrite)
+			if err != nil {
+				// An existing file has shown up in between the dry run and the actual copy operation.
+				if os.IsExist(err) {
+					return newExistingFilesError([]string{filepath.Base(dest)})
+				}
+				return err
+			}
+			written[dest] = true
+			return nil
+		})
 		if err != nil {
 			return err
 		}
+	}
+	return nil
+}
+
+// sourceDirs returns the template cache directories to overlay onto a destination directory, in
+// order from the root of the template's ancestry (e.g. "default") down to the template itself.
+func (template Template) sourceDirs() ([]string, error) {
+	chain, err := templateAncestry(template)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := make([]string, len(chain))
+	for i, t := range chain {
+		dir, err := GetTemplateDir(t.Name)
+		if err != nil {
+			return nil, err
+		}
+		dirs[i] = dir
+	}
+	return dirs, nil
+}
+
+// templateAncestry resolves template's Parent chain, falling back to the "default" template when
+// no Parent is declared, and returns the chain ordered from the root ancestor to template itself.
+func templateAncestry(template Template) ([]Template, error) {
+	var chain []Template
+	cur := template
+	visited := map[string]bool{cur.Name: true}
 
-		// Transform only if it isn't a binary file.
-		result := b
-		if !isBinary(b) {
-			transformed := transform(string(b), projectName, projectDescription)
-			result = []byte(transformed)
+	for {
+		chain = append([]Template{cur}, chain...)
+
+		parentName := cur.Parent
+		if parentName == "" && cur.Name != defaultTemplateName {
+			if dir, err := GetTemplateDir(defaultTemplateName); err == nil {
+				if info, statErr := os.Stat(dir); statErr == nil && info.IsDir() {
+					parentName = defaultTemplateName
+				}
+			}
+		}
+		if parentName == "" || visited[parentName] {
+			break
 		}
 
-		// Write to the destination file.
-		err = writeAllBytes(dest, result, force)
+		parent, err := LoadLocalTemplate(parentName)
 		if err != nil {
-			// An existing file has shown up in between the dry run and the actual copy operation.
-			if os.IsExist(err) {
-				return newExistingFilesError([]string{filepath.Base(dest)})
+			if os.IsNotExist(err) {
+				break
 			}
+			return nil, err
 		}
-		return err
-	})
+
+		visited[parentName] = true
+		cur = parent
+	}
+
+	return chain, nil
 }
 
 // GetTemplateDir returns the directory in which templates on the current machine are stored.
@@ -270,15 +482,45 @@ func getValidProjectName(name string) string {
 	return result
 }
 
-// extractTarball extracts the tarball to the specified destination directory.
+// MaxTemplateTarballSize caps the total decompressed size extractTarball will write for a single
+// template tarball, to guard against zip-bomb-style archives when InstallTemplate is fed a
+// tarball from an untrusted remote source. Callers that need a different limit (e.g. for a
+// registry known to publish larger templates) may adjust this before calling InstallTemplate.
+var MaxTemplateTarballSize int64 = 256 * 1024 * 1024 // 256 MiB
+
+// extractTarball extracts the tarball to the specified destination directory. Entries whose
+// resolved path (or, for symlinks/hardlinks, resolved link target) would escape destDir are
+// rejected, and extraction stops once MaxTemplateTarballSize decompressed bytes have been written.
+//
+// Windows line-ending conversion happens inline as each regular file is extracted (see
+// newLineEndingWriter), rather than as a second whole-tree pass over the extracted files.
 func extractTarball(tarball io.ReadCloser, destDir string) error {
-	// Unzip and untar the file as we go.
+	// Read the whole (compressed) archive up front: the manifest's EOL rules, if any, need to be
+	// known before we stream-extract the files they apply to, and the archive itself is small
+	// relative to the tree it expands into. Bound the read so an oversized or unbounded stream
+	// can't be buffered into memory before the decompressed-size check below ever runs.
 	defer contract.IgnoreClose(tarball)
-	gzr, err := gzip.NewReader(tarball)
+	archive, err := ioutil.ReadAll(io.LimitReader(tarball, MaxTemplateTarballSize+1))
+	if err != nil {
+		return errors.Wrapf(err, "reading template archive")
+	}
+	if int64(len(archive)) > MaxTemplateTarballSize {
+		return errors.Errorf("refusing to untar template archive: exceeds the %d byte limit", MaxTemplateTarballSize)
+	}
+
+	eolRules, err := scanManifestEOLRules(archive)
+	if err != nil {
+		return err
+	}
+
+	gzr, err := gzip.NewReader(bytes.NewReader(archive))
 	if err != nil {
 		return errors.Wrapf(err, "unzipping")
 	}
 	r := tar.NewReader(gzr)
+
+	cleanDestDir := filepath.Clean(destDir)
+	var extracted int64
 	for {
 		header, err := r.Next()
 		if err == io.EOF {
@@ -287,26 +529,61 @@ func extractTarball(tarball io.ReadCloser, destDir string) error {
 			return errors.Wrapf(err, "untarring")
 		}
 
-		path := filepath.Join(destDir, header.Name)
+		path, err := sanitizeTarPath(cleanDestDir, header.Name)
+		if err != nil {
+			return err
+		}
 
 		switch header.Typeflag {
 		case tar.TypeDir:
 			// Create any directories as needed.
-			if _, err := os.Stat(path); err != nil {
-				if err = os.MkdirAll(path, 0700); err != nil {
-					return errors.Wrapf(err, "untarring dir %s", path)
-				}
+			if err = os.MkdirAll(path, 0700); err != nil {
+				return errors.Wrapf(err, "untarring dir %s", path)
 			}
+
 		case tar.TypeReg:
-			// Expand files into the target directory.
-			dst, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, os.FileMode(header.Mode))
+			extracted += header.Size
+			if extracted > MaxTemplateTarballSize {
+				return errors.Errorf(
+					"refusing to untar %s: decompressed size exceeds the %d byte limit", header.Name, MaxTemplateTarballSize)
+			}
+
+			// Make sure the parent directory exists; tar entries aren't guaranteed to list
+			// directories before the files within them.
+			if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+				return errors.Wrapf(err, "untarring dir %s", filepath.Dir(path))
+			}
+
+			eolAttr := resolveEOLAttribute(header.Name, eolRules)
+			if err = extractTarFile(path, r, header, eolAttr); err != nil {
+				return err
+			}
+
+		case tar.TypeSymlink:
+			if _, err = sanitizeTarLinkTarget(cleanDestDir, path, header.Linkname); err != nil {
+				return err
+			}
+			// Tar entries aren't guaranteed to list directories before the files/links within
+			// them.
+			if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+				return errors.Wrapf(err, "untarring dir %s", filepath.Dir(path))
+			}
+			if err = os.Symlink(header.Linkname, path); err != nil {
+				return errors.Wrapf(err, "untarring symlink %s", path)
+			}
+
+		case tar.TypeLink:
+			target, err := sanitizeTarLinkTarget(cleanDestDir, path, header.Linkname)
 			if err != nil {
-				return errors.Wrapf(err, "opening file %s for untar", path)
+				return err
+			}
+			if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+				return errors.Wrapf(err, "untarring dir %s", filepath.Dir(path))
 			}
-			defer contract.IgnoreClose(dst)
-			if _, err = io.Copy(dst, r); err != nil {
-				return errors.Wrapf(err, "untarring file %s", path)
+			if err = os.Link(target, path); err != nil {
+				return errors.Wrapf(err, "untarring hardlink %s", path)
 			}
+
 		default:
 			return errors.Errorf("unexpected plugin file type %s (%v)", header.Name, header.Typeflag)
 		}
@@ -314,6 +591,282 @@ func extractTarball(tarball io.ReadCloser, destDir string) error {
 	return nil
 }
 
+// extractTarFile writes a single regular file entry from r to path, closing the destination
+// file as soon as the copy completes rather than deferring the close until extractTarball returns.
+// eolAttr (from resolveEOLAttribute) selects whether and how line endings are normalized as the
+// file is written.
+func extractTarFile(path string, r *tar.Reader, header *tar.Header, eolAttr string) error {
+	dst, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, os.FileMode(header.Mode))
+	if err != nil {
+		return errors.Wrapf(err, "opening file %s for untar", path)
+	}
+
+	w := newLineEndingWriter(dst, eolAttr)
+	_, copyErr := io.CopyN(w, r, header.Size)
+	writerCloseErr := w.Close()
+	closeErr := dst.Close()
+
+	if copyErr != nil && copyErr != io.EOF {
+		return errors.Wrapf(copyErr, "untarring file %s", path)
+	}
+	if writerCloseErr != nil {
+		return errors.Wrapf(writerCloseErr, "normalizing line endings in %s", path)
+	}
+	if closeErr != nil {
+		return errors.Wrapf(closeErr, "closing file %s after untar", path)
+	}
+	return nil
+}
+
+// maxManifestSize caps how many bytes of a .pulumi.template.yaml entry scanManifestEOLRules will
+// read, so a tarball can't use an oversized declared manifest size to defeat the decompressed
+// archive size limit before that check otherwise applies.
+const maxManifestSize = 1 << 20 // 1 MiB
+
+// scanManifestEOLRules makes a throwaway pass over the (already-buffered) archive looking for the
+// template manifest, and returns its EOL rules if present.
+func scanManifestEOLRules(archive []byte) ([]TemplateEOLRule, error) {
+	gzr, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unzipping")
+	}
+	defer contract.IgnoreClose(gzr)
+
+	r := tar.NewReader(gzr)
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			return nil, nil
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "untarring")
+		}
+
+		if filepath.Base(header.Name) != pulumiTemplateManifestFile {
+			continue
+		}
+
+		// The manifest is a small, hand-written YAML file; an oversized entry of this name is
+		// a malicious or corrupt archive, not a legitimate manifest. Reject it before reading it
+		// into memory, rather than letting ioutil.ReadAll buffer an attacker-declared size.
+		if header.Size > maxManifestSize {
+			return nil, errors.Errorf(
+				"refusing to read %s: exceeds the %d byte manifest size limit", header.Name, maxManifestSize)
+		}
+
+		b, err := ioutil.ReadAll(io.LimitReader(r, maxManifestSize))
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s", header.Name)
+		}
+
+		var manifest Template
+		if err = yaml.Unmarshal(b, &manifest); err != nil {
+			return nil, errors.Wrapf(err, "parsing %s", header.Name)
+		}
+		return manifest.EOL, nil
+	}
+}
+
+// resolveEOLAttribute returns the .gitattributes-style attribute ("text", "binary", "eol=lf", or
+// "eol=crlf") that applies to name, per the last matching rule in rules, or "" if none match.
+func resolveEOLAttribute(name string, rules []TemplateEOLRule) string {
+	var attr string
+	for _, rule := range rules {
+		if matchesGitattributesPattern(rule.Pattern, name) {
+			attr = rule.Attribute
+		}
+	}
+	return attr
+}
+
+// matchesAnyPattern reports whether name matches any of patterns, using the same .gitattributes-
+// style rules as resolveEOLAttribute.
+func matchesAnyPattern(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matchesGitattributesPattern(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesGitattributesPattern reports whether name matches pattern using the same matching rules
+// as .gitattributes: a pattern with no "/" matches a file of that name at any depth, while a
+// pattern containing "/" matches the full path from the template root.
+func matchesGitattributesPattern(pattern string, name string) bool {
+	candidate := name
+	if !strings.Contains(pattern, "/") {
+		candidate = path.Base(name)
+	}
+	matched, err := path.Match(pattern, candidate)
+	return err == nil && matched
+}
+
+// relOrBase returns dest's path relative to destDir, falling back to dest's base name if a
+// relative path can't be computed (e.g. dest isn't actually under destDir).
+func relOrBase(destDir string, dest string) string {
+	rel, err := filepath.Rel(destDir, dest)
+	if err != nil {
+		return filepath.Base(dest)
+	}
+	return rel
+}
+
+// newLineEndingWriter wraps w so that writes through it have their line endings normalized
+// according to attr:
+//
+//   - "binary": no conversion.
+//   - "eol=lf": no conversion (the source is assumed to already use LF).
+//   - "eol=crlf": always convert LF to CRLF, regardless of the host OS.
+//   - "text", or "" (no rule): convert LF to CRLF only on Windows, and only if a sniff of the
+//     first 8000 bytes (matching isBinary) doesn't flag the file as binary.
+//
+// The returned writer must be Close()d after the final Write to flush any buffered sniff data.
+func newLineEndingWriter(w io.Writer, attr string) io.WriteCloser {
+	switch attr {
+	case "binary", "eol=lf":
+		return nopWriteCloser{w}
+	case "eol=crlf":
+		return &crlfWriter{w: w}
+	default:
+		if runtime.GOOS != "windows" {
+			return nopWriteCloser{w}
+		}
+		return &sniffingLineEndingWriter{w: w}
+	}
+}
+
+type nopWriteCloser struct {
+	w io.Writer
+}
+
+func (n nopWriteCloser) Write(p []byte) (int, error) { return n.w.Write(p) }
+func (n nopWriteCloser) Close() error                { return nil }
+
+// crlfWriter rewrites LF to CRLF as bytes are streamed through it, tracking a trailing CR across
+// Write calls so the conversion is correct regardless of how the caller chunks its writes.
+type crlfWriter struct {
+	w         io.Writer
+	pendingCR bool
+}
+
+func (c *crlfWriter) Write(p []byte) (int, error) {
+	var out bytes.Buffer
+	for _, b := range p {
+		if c.pendingCR {
+			c.pendingCR = false
+			if b != '\n' {
+				out.WriteByte('\r')
+			}
+		}
+		if b == '\r' {
+			c.pendingCR = true
+			continue
+		}
+		if b == '\n' {
+			out.WriteString("\r\n")
+			continue
+		}
+		out.WriteByte(b)
+	}
+	if _, err := c.w.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *crlfWriter) Close() error {
+	if c.pendingCR {
+		c.pendingCR = false
+		if _, err := c.w.Write([]byte{'\r'}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sniffingLineEndingWriter buffers up to the first 8000 bytes written to it, uses isBinary to
+// decide whether the file is text, and only then starts passing bytes through either unmodified
+// or via a crlfWriter. If fewer than 8000 bytes are ever written, Close forces the decision.
+type sniffingLineEndingWriter struct {
+	w       io.Writer
+	buf     []byte
+	decided bool
+	inner   io.WriteCloser
+}
+
+const lineEndingSniffSize = 8000
+
+func (s *sniffingLineEndingWriter) Write(p []byte) (int, error) {
+	total := len(p)
+
+	if !s.decided {
+		remaining := lineEndingSniffSize - len(s.buf)
+		if remaining > len(p) {
+			remaining = len(p)
+		}
+		s.buf = append(s.buf, p[:remaining]...)
+		p = p[remaining:]
+
+		if len(s.buf) >= lineEndingSniffSize {
+			if err := s.decide(); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if s.decided && len(p) > 0 {
+		if _, err := s.inner.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+func (s *sniffingLineEndingWriter) decide() error {
+	s.decided = true
+	if isBinary(s.buf) {
+		s.inner = nopWriteCloser{s.w}
+	} else {
+		s.inner = &crlfWriter{w: s.w}
+	}
+	_, err := s.inner.Write(s.buf)
+	s.buf = nil
+	return err
+}
+
+func (s *sniffingLineEndingWriter) Close() error {
+	if !s.decided {
+		if err := s.decide(); err != nil {
+			return err
+		}
+	}
+	return s.inner.Close()
+}
+
+// sanitizeTarPath joins name onto destDir and ensures the result doesn't escape destDir via
+// ".." components or an absolute path, guarding against zip-slip style tarballs.
+func sanitizeTarPath(destDir string, name string) (string, error) {
+	path := filepath.Join(destDir, name)
+	if path != destDir && !strings.HasPrefix(path, destDir+string(os.PathSeparator)) {
+		return "", errors.Errorf("illegal tar path %q escapes destination directory", name)
+	}
+	return path, nil
+}
+
+// sanitizeTarLinkTarget resolves a symlink/hardlink target relative to the link's own location
+// and ensures the resolved path doesn't escape destDir.
+func sanitizeTarLinkTarget(destDir string, linkPath string, linkname string) (string, error) {
+	target := linkname
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(linkPath), target)
+	}
+	target = filepath.Clean(target)
+	if target != destDir && !strings.HasPrefix(target, destDir+string(os.PathSeparator)) {
+		return "", errors.Errorf("illegal tar link target %q escapes destination directory", linkname)
+	}
+	return target, nil
+}
+
 // walkFiles is a helper that walks the directories/files in a source directory
 // and performs an action for each item.
 func walkFiles(sourceDir string, destDir string,
@@ -383,12 +936,57 @@ func newExistingFilesError(existing []string) error {
 	return errors.New(message)
 }
 
-// transform returns a new string with ${PROJECT} and ${DESCRIPTION} replaced by
-// the value of projectName and projectDescription.
-func transform(content string, projectName string, projectDescription string) string {
-	content = strings.Replace(content, "${PROJECT}", projectName, -1)
-	content = strings.Replace(content, "${DESCRIPTION}", projectDescription, -1)
-	return content
+// conditionalFilePrefix marks a template file as only applying when a named variable is truthy,
+// e.g. "_if_typescript_tsconfig.json" is only copied when variables["typescript"] is truthy, and
+// is copied as "tsconfig.json".
+const conditionalFilePrefix = "_if_"
+
+// resolveConditionalDest inspects dest's file name for a conditionalFilePrefix marker. It returns
+// the (possibly rewritten) destination path and whether the file should be included at all.
+func resolveConditionalDest(dest string, variables TemplateValues) (string, bool) {
+	name := filepath.Base(dest)
+	if !strings.HasPrefix(name, conditionalFilePrefix) {
+		return dest, true
+	}
+
+	rest := strings.TrimPrefix(name, conditionalFilePrefix)
+	parts := strings.SplitN(rest, "_", 2)
+	if len(parts) != 2 {
+		return dest, true
+	}
+
+	varName, fileName := parts[0], parts[1]
+	if !isTruthy(variables[varName]) {
+		return dest, false
+	}
+	return filepath.Join(filepath.Dir(dest), fileName), true
+}
+
+// isTruthy reports whether a variable's string value should be treated as "true" when used to
+// gate a conditional file.
+func isTruthy(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "yes", "y", "1":
+		return true
+	default:
+		return false
+	}
+}
+
+// renderTemplateFile renders content as a text/template named after source (for error messages),
+// using variables as the template data so files can reference both user-declared variables and
+// built-ins such as {{.Project}}, {{.Description}}, {{.Author}}, and {{.Year}}.
+func renderTemplateFile(source string, content string, variables TemplateValues) (string, error) {
+	tmpl, err := template.New(filepath.Base(source)).Parse(content)
+	if err != nil {
+		return "", errors.Wrapf(err, "parsing %s as a template", source)
+	}
+
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, variables); err != nil {
+		return "", errors.Wrapf(err, "rendering %s", source)
+	}
+	return buf.String(), nil
 }
 
 // writeAllBytes writes the bytes to the specified file, with an option to overwrite.
@@ -429,39 +1027,3 @@ func isBinary(bytes []byte) bool {
 
 	return false
 }
-
-// fixWindowsLineEndings will go through the sourceDir, read each file, replace \n with \r\n,
-// and save the changes.
-// It'd be more efficient to do this during tarball extraction, but this is sufficient for now.
-func fixWindowsLineEndings(sourceDir string) error {
-	return walkFiles(sourceDir, sourceDir, func(info os.FileInfo, source string, dest string) error {
-		// Skip directories.
-		if info.IsDir() {
-			return nil
-		}
-
-		// Read the source file.
-		b, err := ioutil.ReadFile(source)
-		if err != nil {
-			return err
-		}
-
-		// Transform only if it isn't a binary file.
-		result := b
-		if !isBinary(b) {
-			content := string(b)
-			content = strings.Replace(content, "\n", "\r\n", -1)
-			result = []byte(content)
-		}
-
-		// Write to the destination file.
-		err = writeAllBytes(dest, result, true /*overwrite*/)
-		if err != nil {
-			// An existing file has shown up in between the dry run and the actual copy operation.
-			if os.IsExist(err) {
-				return newExistingFilesError([]string{filepath.Base(dest)})
-			}
-		}
-		return err
-	})
-}
@@ -0,0 +1,281 @@
+// Copyright 2016-2018, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizeTarPath(t *testing.T) {
+	destDir := filepath.Join(string(os.PathSeparator), "dest")
+
+	tests := []struct {
+		name    string
+		entry   string
+		wantErr bool
+	}{
+		{"plain file", "foo.txt", false},
+		{"nested file", "a/b/c.txt", false},
+		{"parent traversal", "../escape.txt", true},
+		{"nested parent traversal", "a/../../escape.txt", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, err := sanitizeTarPath(destDir, tt.entry)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Empty(t, path)
+			} else {
+				assert.NoError(t, err)
+				assert.True(t, strings.HasPrefix(path, destDir))
+			}
+		})
+	}
+}
+
+func TestSanitizeTarLinkTarget(t *testing.T) {
+	destDir := filepath.Join(string(os.PathSeparator), "dest")
+	linkPath := filepath.Join(destDir, "link")
+
+	tests := []struct {
+		name     string
+		linkname string
+		wantErr  bool
+	}{
+		{"sibling file", "sibling.txt", false},
+		{"nested relative", "sub/target.txt", false},
+		{"parent traversal", "../../escape.txt", true},
+		{"absolute outside destDir", "/etc/passwd", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, err := sanitizeTarLinkTarget(destDir, linkPath, tt.linkname)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Empty(t, target)
+			} else {
+				assert.NoError(t, err)
+				assert.True(t, strings.HasPrefix(target, destDir))
+			}
+		})
+	}
+}
+
+func TestResolveEOLAttribute(t *testing.T) {
+	rules := []TemplateEOLRule{
+		{Pattern: "*.txt", Attribute: "text"},
+		{Pattern: "*.bin", Attribute: "binary"},
+		{Pattern: "vendor/*.txt", Attribute: "eol=lf"},
+	}
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"basename pattern matches nested file", "a/b/readme.txt", "text"},
+		{"full path pattern overrides an earlier, more general rule", "vendor/manifest.txt", "eol=lf"},
+		{"full path pattern does not match outside its own path", "other/vendor.txt", "text"},
+		{"binary extension", "assets/logo.bin", "binary"},
+		{"no match", "Makefile", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, resolveEOLAttribute(tt.path, rules))
+		})
+	}
+}
+
+func TestCrlfWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{"no newlines", []string{"hello"}, "hello"},
+		{"single lf", []string{"a\nb"}, "a\r\nb"},
+		{"already crlf is left alone", []string{"a\r\nb"}, "a\r\nb"},
+		{"lf split across writes", []string{"a", "\nb"}, "a\r\nb"},
+		{"cr at end of one write, lf starting the next", []string{"a\r", "\nb"}, "a\r\nb"},
+		{"lone cr not followed by lf", []string{"a\r", "b"}, "a\rb"},
+		{"lone trailing cr flushed on close", []string{"a\r"}, "a\r"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := &crlfWriter{w: &buf}
+			for _, chunk := range tt.writes {
+				n, err := w.Write([]byte(chunk))
+				assert.NoError(t, err)
+				assert.Equal(t, len(chunk), n)
+			}
+			assert.NoError(t, w.Close())
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
+
+func TestResolveConditionalDest(t *testing.T) {
+	tests := []struct {
+		name      string
+		dest      string
+		variables TemplateValues
+		wantDest  string
+		wantOK    bool
+	}{
+		{"non-conditional file", "/dir/tsconfig.json", nil, "/dir/tsconfig.json", true},
+		{"truthy variable includes and strips prefix",
+			"/dir/_if_typescript_tsconfig.json", TemplateValues{"typescript": "true"}, "/dir/tsconfig.json", true},
+		{"falsy variable excludes",
+			"/dir/_if_typescript_tsconfig.json", TemplateValues{"typescript": "false"}, "/dir/_if_typescript_tsconfig.json", false},
+		{"missing variable is falsy",
+			"/dir/_if_typescript_tsconfig.json", TemplateValues{}, "/dir/_if_typescript_tsconfig.json", false},
+		{"malformed marker with no trailing name is left alone",
+			"/dir/_if_onlyvar", nil, "/dir/_if_onlyvar", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest, ok := resolveConditionalDest(tt.dest, tt.variables)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.wantDest, dest)
+		})
+	}
+}
+
+func TestIsTruthy(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"true", true},
+		{"True", true},
+		{"yes", true},
+		{"y", true},
+		{"1", true},
+		{" true ", true},
+		{"false", false},
+		{"no", false},
+		{"0", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			assert.Equal(t, tt.want, isTruthy(tt.value))
+		})
+	}
+}
+
+// TestCopyTemplateFilesOverlay exercises the parent/default overlay behavior of copyTemplateFiles
+// directly against temporary directories, bypassing GetTemplateDir (which resolves against the
+// real user's home directory and so isn't suitable to drive from a test).
+func TestCopyTemplateFilesOverlay(t *testing.T) {
+	root := mustTempDir(t)
+	defer os.RemoveAll(root)
+
+	parentDir := filepath.Join(root, "default")
+	childDir := filepath.Join(root, "child")
+	destDir := filepath.Join(root, "dest")
+
+	mustWriteFile(t, filepath.Join(parentDir, "shared.txt"), "from parent")
+	mustWriteFile(t, filepath.Join(parentDir, "overridden.txt"), "from parent")
+	mustWriteFile(t, filepath.Join(childDir, "overridden.txt"), "from child")
+	mustWriteFile(t, filepath.Join(childDir, "only-child.txt"), "from child")
+	assert.NoError(t, os.MkdirAll(destDir, 0700))
+
+	err := copyTemplateFiles([]string{parentDir, childDir}, destDir, false /*force*/, TemplateValues{}, nil /*noRenderPatterns*/)
+	assert.NoError(t, err)
+
+	assertFileContains(t, filepath.Join(destDir, "shared.txt"), "from parent")
+	assertFileContains(t, filepath.Join(destDir, "overridden.txt"), "from child")
+	assertFileContains(t, filepath.Join(destDir, "only-child.txt"), "from child")
+}
+
+// TestCopyTemplateFilesNoRenderGating checks that a file matching a NoRender pattern is copied
+// byte for byte instead of being run through text/template substitution, so a file that happens to
+// contain its own literal "{{ ... }}" syntax (e.g. a GitHub Actions workflow using
+// "${{ secrets.X }}") isn't corrupted by variable substitution, while every other file keeps the
+// historical behavior of being rendered.
+func TestCopyTemplateFilesNoRenderGating(t *testing.T) {
+	root := mustTempDir(t)
+	defer os.RemoveAll(root)
+
+	sourceDir := filepath.Join(root, "source")
+	destDir := filepath.Join(root, "dest")
+
+	mustWriteFile(t, filepath.Join(sourceDir, "README.md"), "Hello {{.Project}}!")
+	mustWriteFile(t, filepath.Join(sourceDir, ".github", "workflows", "ci.yml"), "run: echo ${{ secrets.TOKEN }}")
+	assert.NoError(t, os.MkdirAll(destDir, 0700))
+
+	variables := TemplateValues{"Project": "my-app"}
+	noRenderPatterns := []string{".github/workflows/ci.yml"}
+	err := copyTemplateFiles([]string{sourceDir}, destDir, false /*force*/, variables, noRenderPatterns)
+	assert.NoError(t, err)
+
+	assertFileContains(t, filepath.Join(destDir, "README.md"), "Hello my-app!")
+	assertFileContains(t, filepath.Join(destDir, ".github", "workflows", "ci.yml"), "run: echo ${{ secrets.TOKEN }}")
+}
+
+// TestCopyTemplateFilesDryRunOverlay checks that the dry run only reports files that would
+// actually land in destDir, honoring the same overlay and conditional-file rules as the real copy.
+func TestCopyTemplateFilesDryRunOverlay(t *testing.T) {
+	root := mustTempDir(t)
+	defer os.RemoveAll(root)
+
+	parentDir := filepath.Join(root, "default")
+	childDir := filepath.Join(root, "child")
+	destDir := filepath.Join(root, "dest")
+
+	mustWriteFile(t, filepath.Join(parentDir, "existing.txt"), "from parent")
+	mustWriteFile(t, filepath.Join(parentDir, "_if_feature_gated.txt"), "from parent")
+	mustWriteFile(t, filepath.Join(childDir, "existing.txt"), "from child")
+	mustWriteFile(t, filepath.Join(destDir, "existing.txt"), "already here")
+	mustWriteFile(t, filepath.Join(destDir, "gated.txt"), "already here")
+
+	err := copyTemplateFilesDryRun([]string{parentDir, childDir}, destDir, TemplateValues{"feature": "false"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "existing.txt")
+	assert.Equal(t, 1, strings.Count(err.Error(), "existing.txt"))
+	assert.NotContains(t, err.Error(), "gated.txt")
+}
+
+func mustTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "pulumi-templates-test")
+	assert.NoError(t, err)
+	return dir
+}
+
+func mustWriteFile(t *testing.T, path string, content string) {
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+}
+
+func assertFileContains(t *testing.T, path string, want string) {
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, want, string(b))
+}